@@ -12,7 +12,11 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -48,10 +52,14 @@ func init() {
 }
 
 type buffer struct {
-	buf [defaulBufferSize]byte
+	buf []byte
 	pos int
 }
 
+func newBuffer(size int) buffer {
+	return buffer{buf: make([]byte, size)}
+}
+
 func (b *buffer) clear() {
 	b.pos = 0
 }
@@ -62,8 +70,8 @@ func (b *buffer) get() []byte {
 
 func (b *buffer) append(data []byte) {
 	l := len(data)
-	if (b.pos + l) > defaulBufferSize {
-		l = defaulBufferSize - b.pos
+	if (b.pos + l) > len(b.buf) {
+		l = len(b.buf) - b.pos
 	}
 	if l > 0 {
 		copy(b.buf[b.pos:b.pos+l], data)
@@ -72,20 +80,59 @@ func (b *buffer) append(data []byte) {
 }
 
 // LoggerConfig encapsulates initializing parameters for the Logger.
-// Filename is the path to the file that the logger opens for writing.
+// Filename is the path to the file that the logger opens for writing; it is a
+// shorthand for Sinks: []Sink{fileSink}, kept for backward compatibility, and
+// is ignored once Sinks is non-empty. MaxSize, MaxLines, Daily, MaxDays,
+// MaxBackups and Compress configure rotation for that file (see FileSinkConfig)
+// and likewise only apply to the Filename shorthand.
 // Callback WriteErrorHandler is called if an error occurred while writing to the Out.
 // Callback SkipHandler is called if there is not enough space in the internal buffer for a new record.
 type LoggerConfig struct {
 	Filename          string
+	Sinks             []Sink
 	SkipHandler       func(int)
 	WriteErrorHandler func(io.Writer)
+
+	MaxSize    int64
+	MaxLines   int64
+	Daily      bool
+	MaxDays    int
+	MaxBackups int
+	Compress   bool
+
+	// Format selects the encoding used by the structured API (Logger.With/Entry).
+	// It has no effect on the raw []byte API. The default is LogfmtFormat.
+	Format Format
+
+	// Buffers and BufferSize override the size of the internal buffer pool
+	// (defaulBuffers buffers of defaulBufferSize bytes by default).
+	Buffers    int
+	BufferSize int
+
+	// CallerInfo, when true, decorates every record from the raw []byte API
+	// with "file:line " right after the level prefix.
+	CallerInfo bool
+
+	// CallerDepth is the number of extra stack frames to skip, beyond the
+	// direct caller of LogInfo/LogError/..., when resolving that file:line.
+	// It is only useful when every call in the process goes through the same
+	// wrapper function; a wrapper used by some call sites but not others
+	// should instead call LogInfoDepth/LogErrorDepth/... directly and pass
+	// its own extra depth there.
+	CallerDepth int
+
+	// StackOnError additionally appends a runtime.Stack dump to ErrorLevel
+	// records, the way FatalLevel records always do once CallerInfo is set.
+	// It only takes effect together with CallerInfo; without it no stack
+	// dump is appended to any record.
+	StackOnError bool
 }
 
 // Logger represents an active logging object that generates lines of output to a logwriter.
 // Multiple goroutines may invoke methods on a Logger simultaneously.
 type Logger struct {
 	writer   *logwriter.LogWriter
-	buffers  [defaulBuffers]buffer
+	buffers  []buffer
 	bufStack chan int
 
 	skipHandler       func(int)
@@ -96,37 +143,78 @@ type Logger struct {
 	timeFormat   string
 	timeStr      *[]byte
 
-	filename string
-	muReopen sync.Mutex
-	file     *os.File
+	format Format
+
+	sink Sink
+
+	vLevel      int32
+	vModule     []vModuleEntry
+	vCache      atomic.Value
+	backtraceAt map[string]bool
+
+	callerInfo   bool
+	callerDepth  int
+	stackOnError bool
 
 	signalChan chan os.Signal
 }
 
+// callerBasenameCache maps a runtime.Caller program counter to the shortened
+// basename of its file, shared by every Logger: runtime.Caller is the
+// expensive part of caller-info decoration, and a given call site's pc always
+// resolves to the same file.
+var callerBasenameCache sync.Map
+
+func shortCallerFile(pc uintptr, file string) string {
+	if v, ok := callerBasenameCache.Load(pc); ok {
+		return v.(string)
+	}
+	base := filepath.Base(file)
+	callerBasenameCache.Store(pc, base)
+	return base
+}
+
 // New creates a new Logger with parameters from LoggerConfig.
 func New(config LoggerConfig) (*Logger, error) {
 	l := new(Logger)
-	l.filename = config.Filename
 	l.skipHandler = config.SkipHandler
 	l.writeErrorHandler = config.WriteErrorHandler
-	f, err := os.OpenFile(l.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 
+	sink, err := newConfiguredSink(config)
 	if err != nil {
 		return nil, err
 	}
+	l.sink = sink
+	l.format = config.Format
+	l.callerInfo = config.CallerInfo
+	l.callerDepth = config.CallerDepth
+	l.stackOnError = config.StackOnError
 
 	l.currentLevel = InfoLevel
 	l.timeFormat = "2006.01.02 15:04:05.00 "
 	s := time.Now().Format(l.timeFormat)
 	sl := []byte(s)
 	l.timeStr = &sl
-	l.file = f
-	l.writer = logwriter.New(logwriter.LogConfig{Out: l.file,
+	l.writer = logwriter.New(logwriter.LogConfig{Out: l.sink,
 		SkipHandler:       l.skipHandler,
 		WriteErrorHandler: l.writeErrorHandler})
 
-	l.bufStack = make(chan int, defaulBuffers)
-	for i := 0; i < defaulBuffers; i++ {
+	l.vCache.Store(&sync.Map{})
+
+	numBuffers := config.Buffers
+	if numBuffers <= 0 {
+		numBuffers = defaulBuffers
+	}
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaulBufferSize
+	}
+	l.buffers = make([]buffer, numBuffers)
+	for i := range l.buffers {
+		l.buffers[i] = newBuffer(bufferSize)
+	}
+	l.bufStack = make(chan int, numBuffers)
+	for i := 0; i < numBuffers; i++ {
 		l.bufStack <- i
 	}
 	log.SetOutput(l.writer)
@@ -138,6 +226,32 @@ func New(config LoggerConfig) (*Logger, error) {
 	return l, nil
 }
 
+// newConfiguredSink builds the Sink described by config: Sinks if given (more
+// than one is fanned out through a MultiSink with no per-sink filtering), or
+// else a FileSink for the Filename/rotation shorthand fields.
+func newConfiguredSink(config LoggerConfig) (Sink, error) {
+	switch len(config.Sinks) {
+	case 0:
+		return NewFileSink(FileSinkConfig{
+			Filename:   config.Filename,
+			MaxSize:    config.MaxSize,
+			MaxLines:   config.MaxLines,
+			Daily:      config.Daily,
+			MaxDays:    config.MaxDays,
+			MaxBackups: config.MaxBackups,
+			Compress:   config.Compress,
+		})
+	case 1:
+		return config.Sinks[0], nil
+	default:
+		entries := make([]SinkLevel, len(config.Sinks))
+		for i, s := range config.Sinks {
+			entries[i] = SinkLevel{Sink: s, Level: TraceLevel}
+		}
+		return NewMultiSink(entries...), nil
+	}
+}
+
 // Set the level of logging. It is possible to set FatalLevel, ErrorLevel, WarnLevel, InfoLevel, DebugLevel, TraceLevel.
 // Records with a level below the set level will be ignored when writing. The default is InfoLevel.
 func (l *Logger) SetLevel(level byte) error {
@@ -158,20 +272,10 @@ func (l *Logger) SetTimeFormat(layout string) {
 	l.muUpdate.Unlock()
 }
 
-// Reopen waits when all previous records are added to the log and again opens a file with the name LoggerConfig.filename.
+// Reopen tells the Logger's Sink to reopen its underlying resource, e.g. a
+// file that was renamed out from under it by an external tool.
 func (l *Logger) Reopen() error {
-	f, err := os.OpenFile(l.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-
-	if err != nil {
-		return err
-	}
-
-	l.muReopen.Lock()
-	l.writer.Reset(f)
-	l.file.Close()
-	l.file = f
-	l.muReopen.Unlock()
-	return nil
+	return l.sink.Reopen()
 }
 
 func (l *Logger) signalLoop() {
@@ -207,54 +311,195 @@ func (l *Logger) getTime() *[]byte {
 	return l.timeStr
 }
 
-func (l *Logger) output(level byte, data []byte) {
+// outputDepth resolves and writes a record at level. depth is the number of
+// extra frames between the true call site and the public Log* method that
+// called this one (0 for a direct call), for wrapper libraries
+// that want caller-info decoration to point past themselves (see
+// LogInfoDepth and friends). CallerInfo/StackOnError resolution and
+// FatalLevel's flush-then-exit both happen inside emit, so Entry (the
+// structured API) gets the same decoration and exit semantics.
+func (l *Logger) outputDepth(level byte, depth int, data []byte) {
+	l.emit(level, depth, func(buf *buffer, callerLoc string, stackDump []byte) {
+		buf.append(*(l.getTime()))
+		buf.append(levelStrings[level])
+		if callerLoc != "" {
+			buf.append([]byte(callerLoc + " "))
+		}
+		buf.append(data)
+		if stackDump != nil {
+			buf.append([]byte("\n"))
+			buf.append(stackDump)
+		}
+	})
+}
+
+// Flush blocks until the logwriter has drained any buffered writes, so a
+// caller can be sure a record reached the sink before e.g. exiting.
+func (l *Logger) Flush() {
+	l.writer.Flush()
+}
+
+// emit checks level against the current threshold and, if it passes, resolves
+// CallerInfo/StackOnError decoration, acquires a pooled buffer, lets fill
+// populate it with those plus whatever else it wants, and hands the result
+// to the writer. emit is always called exactly two stack frames below the
+// public API method (e.g. LogInfo calling outputDepth, or Entry.Info calling
+// Entry.log), so every runtime.Caller lookup below lands on the original public API call
+// once extraDepth (the same wrapper-compensation depth Log*Depth callers
+// pass) and the configured CallerDepth are added on top; callers with no
+// such notion of explicit depth (Entry.log) pass 0 for extraDepth. Resolving
+// this here rather than per-API means both the raw []byte API and Entry get
+// identical caller/backtrace-at/stack-dump decoration instead of only the
+// former.
+//
+// The buffer's first byte is always level itself, ahead of anything fill
+// writes; see Sink for why the level rides along with the record instead of
+// being recovered from the formatted text. emit is also where FatalLevel's
+// flush-then-exit happens, so every entry point into it (outputDepth,
+// Entry.log) gets the same "Fatal never returns" guarantee.
+func (l *Logger) emit(level byte, extraDepth int, fill func(buf *buffer, callerLoc string, stackDump []byte)) {
 	l.muUpdate.Lock()
 	if level > l.currentLevel {
 		l.muUpdate.Unlock()
 		return
 	}
+	callerInfo := l.callerInfo
+	callerDepth := l.callerDepth
+	stackOnError := l.stackOnError
 	l.muUpdate.Unlock()
 
+	skip := 3 + extraDepth + callerDepth
+
+	var callerLoc string
+	var stackDump []byte
+	if callerInfo {
+		if pc, file, line, ok := runtime.Caller(skip); ok {
+			callerLoc = shortCallerFile(pc, file) + ":" + strconv.Itoa(line)
+		}
+		if level == FatalLevel || (stackOnError && level == ErrorLevel) {
+			stackDump = stackTrace()
+		}
+	}
+
 	i := <-l.bufStack
 	defer func(i int) { l.bufStack <- i }(i)
 	l.buffers[i].clear()
-	l.buffers[i].append(*(l.getTime()))
-	l.buffers[i].append(levelStrings[level])
-	l.buffers[i].append(data)
+	l.buffers[i].append([]byte{level})
+	fill(&l.buffers[i], callerLoc, stackDump)
 
 	if l.buffers[i].get()[len(l.buffers[i].get())-1] != '\n' {
 		l.buffers[i].append([]byte("\n"))
 	}
 
-	l.writer.Write(l.buffers[i].get())
+	l.muUpdate.Lock()
+	bt := l.backtraceAt
+	l.muUpdate.Unlock()
+	if len(bt) > 0 {
+		if _, file, line, ok := runtime.Caller(skip); ok {
+			if bt[filepath.Base(file)+":"+strconv.Itoa(line)] {
+				l.buffers[i].append(stackTrace())
+			}
+		}
+	}
+
+	n := l.buffers[i].get()
+	l.writer.Write(n)
+
+	if level == FatalLevel {
+		l.Flush()
+		os.Exit(1)
+	}
 }
 
 // LogFatal appends the Fatal prefix to the data string and writes to a file with the Fatal level.
 func (l *Logger) LogFatal(data []byte) {
-	l.output(FatalLevel, data)
+	l.outputDepth(FatalLevel, 0, data)
 }
 
 // LogError appends the Error prefix to the data string and writes to a file with the Error level.
 func (l *Logger) LogError(data []byte) {
-	l.output(ErrorLevel, data)
+	l.outputDepth(ErrorLevel, 0, data)
 }
 
 // LogWarn appends the Warning prefix to the data string and writes to a file with the Warn level.
 func (l *Logger) LogWarn(data []byte) {
-	l.output(WarnLevel, data)
+	l.outputDepth(WarnLevel, 0, data)
 }
 
 // LogInfo appends the Info prefix to the data string and writes to a file with the Info level.
 func (l *Logger) LogInfo(data []byte) {
-	l.output(InfoLevel, data)
+	l.outputDepth(InfoLevel, 0, data)
 }
 
 // LogDebug appends the Debug prefix to the data string and writes to a file with the Debug level.
 func (l *Logger) LogDebug(data []byte) {
-	l.output(DebugLevel, data)
+	l.outputDepth(DebugLevel, 0, data)
 }
 
 // LogTrace appends the Trace prefix to the data string and writes to a file with the Trace level.
 func (l *Logger) LogTrace(data []byte) {
-	l.output(TraceLevel, data)
+	l.outputDepth(TraceLevel, 0, data)
+}
+
+// LogFatalDepth is LogFatal, but resolves caller-info depth extra frames
+// above its own caller. Wrapper libraries use this (and the other *Depth
+// variants below) so the file:line CallerInfo reports is the wrapper's
+// caller rather than the wrapper itself.
+func (l *Logger) LogFatalDepth(depth int, data []byte) {
+	l.outputDepth(FatalLevel, depth, data)
+}
+
+// LogErrorDepth is LogError with an extra caller-info depth; see LogFatalDepth.
+func (l *Logger) LogErrorDepth(depth int, data []byte) {
+	l.outputDepth(ErrorLevel, depth, data)
+}
+
+// LogWarnDepth is LogWarn with an extra caller-info depth; see LogFatalDepth.
+func (l *Logger) LogWarnDepth(depth int, data []byte) {
+	l.outputDepth(WarnLevel, depth, data)
+}
+
+// LogInfoDepth is LogInfo with an extra caller-info depth; see LogFatalDepth.
+func (l *Logger) LogInfoDepth(depth int, data []byte) {
+	l.outputDepth(InfoLevel, depth, data)
+}
+
+// LogDebugDepth is LogDebug with an extra caller-info depth; see LogFatalDepth.
+func (l *Logger) LogDebugDepth(depth int, data []byte) {
+	l.outputDepth(DebugLevel, depth, data)
+}
+
+// LogTraceDepth is LogTrace with an extra caller-info depth; see LogFatalDepth.
+func (l *Logger) LogTraceDepth(depth int, data []byte) {
+	l.outputDepth(TraceLevel, depth, data)
+}
+
+// LogFatalf formats according to format and writes the result with the Fatal level.
+func (l *Logger) LogFatalf(format string, args ...interface{}) {
+	l.outputDepth(FatalLevel, 0, []byte(fmt.Sprintf(format, args...)))
+}
+
+// LogErrorf formats according to format and writes the result with the Error level.
+func (l *Logger) LogErrorf(format string, args ...interface{}) {
+	l.outputDepth(ErrorLevel, 0, []byte(fmt.Sprintf(format, args...)))
+}
+
+// LogWarnf formats according to format and writes the result with the Warn level.
+func (l *Logger) LogWarnf(format string, args ...interface{}) {
+	l.outputDepth(WarnLevel, 0, []byte(fmt.Sprintf(format, args...)))
+}
+
+// LogInfof formats according to format and writes the result with the Info level.
+func (l *Logger) LogInfof(format string, args ...interface{}) {
+	l.outputDepth(InfoLevel, 0, []byte(fmt.Sprintf(format, args...)))
+}
+
+// LogDebugf formats according to format and writes the result with the Debug level.
+func (l *Logger) LogDebugf(format string, args ...interface{}) {
+	l.outputDepth(DebugLevel, 0, []byte(fmt.Sprintf(format, args...)))
+}
+
+// LogTracef formats according to format and writes the result with the Trace level.
+func (l *Logger) LogTracef(format string, args ...interface{}) {
+	l.outputDepth(TraceLevel, 0, []byte(fmt.Sprintf(format, args...)))
 }
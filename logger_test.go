@@ -1,9 +1,14 @@
 package logger
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -28,7 +33,6 @@ func TestCreateLogger(t *testing.T) {
 	lg.LogInfo([]byte("LogInfo"))
 	lg.LogWarn([]byte("LogWarn"))
 	lg.LogError([]byte("LogError"))
-	lg.LogFatal([]byte("LogFatal"))
 	time.Sleep(300 * time.Millisecond)
 	file, _ := os.Open("./TestCreateLogger.log")
 	all, _ := ioutil.ReadAll(file)
@@ -53,9 +57,6 @@ func TestCreateLogger(t *testing.T) {
 		t.Error("Expected presence LogError, got\n", string(all))
 	}
 
-	if !strings.Contains(string(all), "LogFatal") {
-		t.Error("Expected presence LogFatal, got\n", string(all))
-	}
 	os.Remove("./TestCreateLogger.log")
 }
 
@@ -94,7 +95,7 @@ func TestFailCreateLogger(t *testing.T) {
 	}
 
 	lg, err := New(LoggerConfig{Filename: "./TestFailCreateLogger.log"})
-	lg.filename = "/"
+	lg.sink.(*FileSink).filename = "/"
 	err = lg.Reopen()
 
 	if err == nil {
@@ -162,7 +163,7 @@ func TestTimeFormat(t *testing.T) {
 }
 
 func TestBuffer(t *testing.T) {
-	var buf buffer
+	buf := newBuffer(defaulBufferSize)
 	for i := 0; i < defaulBufferSize-1; i++ {
 		buf.append([]byte("0"))
 	}
@@ -174,6 +175,388 @@ func TestBuffer(t *testing.T) {
 	}
 }
 
+func TestRotateMaxSize(t *testing.T) {
+	os.Remove("./TestRotateMaxSize.log")
+	for _, name := range rotateBackupGlob(t, "./TestRotateMaxSize.log") {
+		os.Remove(name)
+	}
+
+	lg, _ := New(LoggerConfig{Filename: "./TestRotateMaxSize.log", MaxSize: 40})
+	for i := 0; i < 20; i++ {
+		lg.LogInfo([]byte("0123456789"))
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	backups := rotateBackupGlob(t, "./TestRotateMaxSize.log")
+	if len(backups) == 0 {
+		t.Error("Expected at least one rotated backup, got none")
+	}
+
+	file, _ := os.Open("./TestRotateMaxSize.log")
+	all, _ := ioutil.ReadAll(file)
+	for _, name := range backups {
+		backupFile, _ := os.Open(name)
+		backupData, _ := ioutil.ReadAll(backupFile)
+		all = append(all, backupData...)
+	}
+	if !strings.Contains(string(all), "0123456789") {
+		t.Error("Expected presence 0123456789, got\n", string(all))
+	}
+
+	os.Remove("./TestRotateMaxSize.log")
+	for _, name := range backups {
+		os.Remove(name)
+	}
+}
+
+// TestRotateConcurrentDaily exercises FileSink.Write and the background
+// rotate() goroutine it triggers from several goroutines at once, with
+// MaxLines forcing frequent rotation and Daily enabled so curDay is also
+// touched on every rotation. Run with -race to catch concurrent
+// read/write access to curDay.
+func TestRotateConcurrentDaily(t *testing.T) {
+	os.Remove("./TestRotateConcurrentDaily.log")
+	for _, name := range rotateBackupGlob(t, "./TestRotateConcurrentDaily.log") {
+		os.Remove(name)
+	}
+
+	fs, err := NewFileSink(FileSinkConfig{Filename: "./TestRotateConcurrentDaily.log", MaxLines: 5, Daily: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				fs.Write([]byte{InfoLevel, 'x', '\n'})
+			}
+		}()
+	}
+	wg.Wait()
+
+	os.Remove("./TestRotateConcurrentDaily.log")
+	for _, name := range rotateBackupGlob(t, "./TestRotateConcurrentDaily.log") {
+		os.Remove(name)
+	}
+}
+
+func rotateBackupGlob(t *testing.T, filename string) []string {
+	matches, err := filepath.Glob(filename + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestSetV(t *testing.T) {
+	os.Remove("./TestSetV.log")
+	lg, _ := New(LoggerConfig{Filename: "./TestSetV.log"})
+
+	lg.V(2).LogInfo([]byte("V2"))
+	lg.SetV(2)
+	lg.V(2).LogInfo([]byte("V2"))
+	lg.V(3).LogInfo([]byte("V3"))
+	time.Sleep(300 * time.Millisecond)
+
+	file, _ := os.Open("./TestSetV.log")
+	all, _ := ioutil.ReadAll(file)
+
+	if strings.Count(string(all), "V2") != 1 {
+		t.Error("Expected once V2, got\n", string(all))
+	}
+
+	if strings.Contains(string(all), "V3") {
+		t.Error("Expected absence V3, got\n", string(all))
+	}
+
+	os.Remove("./TestSetV.log")
+}
+
+func TestVModuleMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		file    string
+		want    bool
+	}{
+		{"client", "/src/pkg/client.go", true},
+		{"client", "/src/pkg/client_test.go", false},
+		{"rpc/*", "/src/pkg/rpc/codec.go", true},
+		{"rpc/*", "/src/pkg/rpc/sub/codec.go", false},
+		{"foo/bar.go", "/src/foo/bar.go", true},
+	}
+
+	for _, c := range cases {
+		if got := vModuleMatch(c.pattern, c.file); got != c.want {
+			t.Errorf("vModuleMatch(%q, %q) = %v, want %v", c.pattern, c.file, got, c.want)
+		}
+	}
+}
+
+func TestMultiSinkLevelFilter(t *testing.T) {
+	os.Remove("./TestMultiSinkLevelFilter.log")
+	fs, err := NewFileSink(FileSinkConfig{Filename: "./TestMultiSinkLevelFilter.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errOnly := &recordingSink{}
+	ms := NewMultiSink(
+		SinkLevel{Sink: fs, Level: TraceLevel},
+		SinkLevel{Sink: errOnly, Level: ErrorLevel},
+	)
+
+	lg, err := New(LoggerConfig{Sinks: []Sink{ms}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg.SetLevel(TraceLevel)
+
+	lg.LogError([]byte("LogError"))
+	lg.LogInfo([]byte("LogInfo"))
+	time.Sleep(300 * time.Millisecond)
+
+	file, _ := os.Open("./TestMultiSinkLevelFilter.log")
+	all, _ := ioutil.ReadAll(file)
+
+	if !strings.Contains(string(all), "LogError") || !strings.Contains(string(all), "LogInfo") {
+		t.Error("Expected file sink to receive both records, got\n", string(all))
+	}
+
+	written := string(bytes.Join(errOnly.writes, nil))
+	if !strings.Contains(written, "LogError") {
+		t.Error("Expected error-only sink to receive LogError, got\n", written)
+	}
+	if strings.Contains(written, "LogInfo") {
+		t.Error("Expected error-only sink to not receive LogInfo, got\n", written)
+	}
+
+	os.Remove("./TestMultiSinkLevelFilter.log")
+}
+
+type recordingSink struct {
+	writes [][]byte
+}
+
+func (s *recordingSink) Write(data []byte) (int, error) {
+	cp := append([]byte(nil), data...)
+	s.writes = append(s.writes, cp)
+	return len(data), nil
+}
+
+func (s *recordingSink) Reopen() error {
+	return nil
+}
+
+// failingSink always errors on Write, to exercise MultiSink's error handling.
+type failingSink struct {
+	err   error
+	calls int
+}
+
+func (s *failingSink) Write(data []byte) (int, error) {
+	s.calls++
+	return 0, s.err
+}
+
+func (s *failingSink) Reopen() error {
+	return nil
+}
+
+// TestMultiSinkWriteError checks that MultiSink.Write still fans out to every
+// matching entry when one of them errors, and returns that error instead of
+// silently reporting success.
+func TestMultiSinkWriteError(t *testing.T) {
+	boom := errors.New("boom")
+	failing := &failingSink{err: boom}
+	recording := &recordingSink{}
+	ms := NewMultiSink(
+		SinkLevel{Sink: failing, Level: TraceLevel},
+		SinkLevel{Sink: recording, Level: TraceLevel},
+	)
+
+	_, err := ms.Write([]byte{InfoLevel, 'x', '\n'})
+	if err != boom {
+		t.Errorf("Expected Write to return %v, got %v", boom, err)
+	}
+	if failing.calls != 1 {
+		t.Errorf("Expected the failing sink to be written to once, got %d", failing.calls)
+	}
+	if len(recording.writes) != 1 {
+		t.Errorf("Expected the later sink to still receive the record despite the earlier failure, got %d writes", len(recording.writes))
+	}
+}
+
+func TestEntryLogfmt(t *testing.T) {
+	os.Remove("./TestEntryLogfmt.log")
+	lg, _ := New(LoggerConfig{Filename: "./TestEntryLogfmt.log"})
+
+	lg.With(String("user", "bob"), Int("attempt", 3)).Info("login")
+	time.Sleep(300 * time.Millisecond)
+
+	file, _ := os.Open("./TestEntryLogfmt.log")
+	all, _ := ioutil.ReadAll(file)
+
+	for _, want := range []string{"level=info", `msg="login"`, `user="bob"`, "attempt=3"} {
+		if !strings.Contains(string(all), want) {
+			t.Error("Expected", want, "got\n", string(all))
+		}
+	}
+
+	os.Remove("./TestEntryLogfmt.log")
+}
+
+func TestEntryJSON(t *testing.T) {
+	os.Remove("./TestEntryJSON.log")
+	lg, _ := New(LoggerConfig{Filename: "./TestEntryJSON.log", Format: JSONFormat})
+
+	lg.With(Bool("ok", true)).Error("failed")
+	time.Sleep(300 * time.Millisecond)
+
+	file, _ := os.Open("./TestEntryJSON.log")
+	all, _ := ioutil.ReadAll(file)
+
+	for _, want := range []string{`"level":"error"`, `"msg":"failed"`, `"ok":true`} {
+		if !strings.Contains(string(all), want) {
+			t.Error("Expected", want, "got\n", string(all))
+		}
+	}
+
+	os.Remove("./TestEntryJSON.log")
+}
+
+// TestMultiSinkLevelFilterStructured guards against the level being
+// recovered by scanning the formatted text: a logfmt record never contains
+// the raw "ERROR: " prefix, so a MultiSink that misclassified it as Info
+// would silently drop it from errOnly below.
+func TestMultiSinkLevelFilterStructured(t *testing.T) {
+	os.Remove("./TestMultiSinkLevelFilterStructured.log")
+	fs, err := NewFileSink(FileSinkConfig{Filename: "./TestMultiSinkLevelFilterStructured.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errOnly := &recordingSink{}
+	ms := NewMultiSink(
+		SinkLevel{Sink: fs, Level: TraceLevel},
+		SinkLevel{Sink: errOnly, Level: ErrorLevel},
+	)
+
+	lg, err := New(LoggerConfig{Sinks: []Sink{ms}, Format: LogfmtFormat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg.SetLevel(TraceLevel)
+
+	lg.With(String("user", "bob")).Error("failed")
+	lg.With(String("user", "bob")).Info("ok")
+	time.Sleep(300 * time.Millisecond)
+
+	written := string(bytes.Join(errOnly.writes, nil))
+	if !strings.Contains(written, `msg="failed"`) {
+		t.Error("Expected error-only sink to receive the logfmt ERROR record, got\n", written)
+	}
+	if strings.Contains(written, `msg="ok"`) {
+		t.Error("Expected error-only sink to not receive the logfmt INFO record, got\n", written)
+	}
+
+	os.Remove("./TestMultiSinkLevelFilterStructured.log")
+}
+
+// TestEntryCallerInfo checks that CallerInfo decorates Entry records too,
+// not just the raw []byte API.
+func TestEntryCallerInfo(t *testing.T) {
+	os.Remove("./TestEntryCallerInfo.log")
+	lg, _ := New(LoggerConfig{Filename: "./TestEntryCallerInfo.log", Format: JSONFormat, CallerInfo: true})
+
+	lg.With(String("user", "bob")).Info("hello")
+	time.Sleep(300 * time.Millisecond)
+
+	file, _ := os.Open("./TestEntryCallerInfo.log")
+	all, _ := ioutil.ReadAll(file)
+
+	if !strings.Contains(string(all), `"caller":"logger_test.go:`) {
+		t.Error("Expected Entry record to carry a caller field, got\n", string(all))
+	}
+
+	os.Remove("./TestEntryCallerInfo.log")
+}
+
+func TestCallerInfo(t *testing.T) {
+	os.Remove("./TestCallerInfo.log")
+	lg, _ := New(LoggerConfig{Filename: "./TestCallerInfo.log", CallerInfo: true})
+
+	lg.LogInfo([]byte("LogInfo"))
+	time.Sleep(300 * time.Millisecond)
+
+	file, _ := os.Open("./TestCallerInfo.log")
+	all, _ := ioutil.ReadAll(file)
+
+	idxLevel := strings.Index(string(all), "INFO: ")
+	idxCaller := strings.Index(string(all), "logger_test.go:")
+	if idxLevel == -1 || idxCaller == -1 || idxCaller < idxLevel {
+		t.Error("Expected caller file:line right after the level prefix, got\n", string(all))
+	}
+
+	os.Remove("./TestCallerInfo.log")
+}
+
+// TestLogFatalExits checks that LogFatal flushes the pending record and then
+// exits the process, using the standard re-exec trick for testing os.Exit.
+func TestLogFatalExits(t *testing.T) {
+	if os.Getenv("LOGGER_TEST_FATAL") == "1" {
+		os.Remove("./TestLogFatalExits.log")
+		lg, _ := New(LoggerConfig{Filename: "./TestLogFatalExits.log"})
+		lg.LogFatal([]byte("boom"))
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestLogFatalExits")
+	cmd.Env = append(os.Environ(), "LOGGER_TEST_FATAL=1")
+	err := cmd.Run()
+
+	if e, ok := err.(*exec.ExitError); !ok || e.Success() {
+		t.Fatalf("process ran with err %v, want exit status 1", err)
+	}
+
+	file, _ := os.Open("./TestLogFatalExits.log")
+	all, _ := ioutil.ReadAll(file)
+	if !strings.Contains(string(all), "boom") {
+		t.Error("Expected Flush to drain the FatalLevel record before exit, got\n", string(all))
+	}
+
+	os.Remove("./TestLogFatalExits.log")
+}
+
+// TestEntryFatalExits checks that Entry.Fatal (the structured API) gets the
+// same flush-then-exit semantics as LogFatal, not just a logged record.
+func TestEntryFatalExits(t *testing.T) {
+	if os.Getenv("LOGGER_TEST_ENTRY_FATAL") == "1" {
+		os.Remove("./TestEntryFatalExits.log")
+		lg, _ := New(LoggerConfig{Filename: "./TestEntryFatalExits.log", Format: JSONFormat})
+		lg.With(String("reason", "boom")).Fatal("fatal")
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestEntryFatalExits")
+	cmd.Env = append(os.Environ(), "LOGGER_TEST_ENTRY_FATAL=1")
+	err := cmd.Run()
+
+	if e, ok := err.(*exec.ExitError); !ok || e.Success() {
+		t.Fatalf("process ran with err %v, want exit status 1", err)
+	}
+
+	file, _ := os.Open("./TestEntryFatalExits.log")
+	all, _ := ioutil.ReadAll(file)
+	if !strings.Contains(string(all), `"msg":"fatal"`) {
+		t.Error("Expected Flush to drain the FatalLevel record before exit, got\n", string(all))
+	}
+
+	os.Remove("./TestEntryFatalExits.log")
+}
+
 func BenchmarkLogSequential(b *testing.B) {
 	os.Remove("./BenchmarkLogSequential.log")
 	lg, _ := New(LoggerConfig{Filename: "./BenchmarkLogSequential.log"})
@@ -0,0 +1,417 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sink is a logging destination that a Logger writes formatted records to.
+// Write's data always carries the record's level as its first byte (see the
+// Level constants), followed by the formatted record itself; a sink that
+// only persists bytes slices it off as data[1:] before using data. Carrying
+// the level this way lets MultiSink and SyslogSink route by the true level
+// instead of recovering it by scanning the formatted text, which breaks for
+// the structured logfmt/JSON records Entry emits (they never carry the
+// "LEVEL: " prefix the raw []byte API does).
+// Reopen re-opens any underlying OS resource (e.g. in response to SIGHUP or
+// to a file that was moved out from under it); sinks with nothing to reopen
+// simply return nil.
+type Sink interface {
+	io.Writer
+	Reopen() error
+}
+
+// FileSinkConfig configures a FileSink, including optional rotation.
+//
+// MaxSize, MaxLines and Daily enable automatic rotation of Filename: once the current file
+// reaches MaxSize bytes or MaxLines records, or the day changes (when Daily is true), it is
+// renamed to "Filename.YYYY-MM-DD.N" (gzip-compressed when Compress is true) and a fresh
+// Filename is opened in its place. MaxBackups and MaxDays bound how many rotated files are
+// kept; the oldest are removed once either limit is exceeded. A zero value disables the
+// corresponding trigger or limit.
+type FileSinkConfig struct {
+	Filename string
+
+	MaxSize    int64
+	MaxLines   int64
+	Daily      bool
+	MaxDays    int
+	MaxBackups int
+	Compress   bool
+}
+
+// FileSink writes records to a file, optionally rotating it by size, line
+// count or day. It is the Sink used when LoggerConfig.Filename is set.
+type FileSink struct {
+	filename string
+	muReopen sync.Mutex
+	file     *os.File
+
+	maxSize    int64
+	maxLines   int64
+	daily      bool
+	maxDays    int
+	maxBackups int
+	compress   bool
+
+	curSize  int64
+	curLines int64
+	curDay   atomic.Value // string, read/written from concurrent Write and rotate goroutines
+	rotating int32
+}
+
+// NewFileSink opens config.Filename for append, creating it if necessary.
+func NewFileSink(config FileSinkConfig) (*FileSink, error) {
+	f, err := os.OpenFile(config.Filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileSink{
+		filename:   config.Filename,
+		file:       f,
+		maxSize:    config.MaxSize,
+		maxLines:   config.MaxLines,
+		daily:      config.Daily,
+		maxDays:    config.MaxDays,
+		maxBackups: config.MaxBackups,
+		compress:   config.Compress,
+	}
+	s.curDay.Store(time.Now().Format("2006-01-02"))
+	if fi, err := f.Stat(); err == nil {
+		s.curSize = fi.Size()
+	}
+	return s, nil
+}
+
+// Write appends data[1:] (data[0] is the record's level, see Sink) to the
+// current file and, if rotation is configured, rotates the file once a
+// threshold is crossed. Rotation itself runs in a background goroutine so
+// Write never blocks on it.
+func (s *FileSink) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	payload := data[1:]
+
+	s.muReopen.Lock()
+	n, err := s.file.Write(payload)
+	s.muReopen.Unlock()
+
+	if s.rotationEnabled() {
+		size := atomic.AddInt64(&s.curSize, int64(n))
+		lines := atomic.AddInt64(&s.curLines, 1)
+		if s.shouldRotate(size, lines) {
+			s.triggerRotate()
+		}
+	}
+	return n + 1, err
+}
+
+// Reopen closes and reopens Filename, e.g. after it was renamed by an
+// external tool such as logrotate. Unlike rotation it does not create a
+// backup file itself.
+func (s *FileSink) Reopen() error {
+	f, err := os.OpenFile(s.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.muReopen.Lock()
+	s.file.Close()
+	s.file = f
+	s.muReopen.Unlock()
+
+	if fi, err := f.Stat(); err == nil {
+		atomic.StoreInt64(&s.curSize, fi.Size())
+	}
+	return nil
+}
+
+// rotationEnabled reports whether any rotation trigger was configured.
+func (s *FileSink) rotationEnabled() bool {
+	return s.maxSize > 0 || s.maxLines > 0 || s.daily
+}
+
+func (s *FileSink) shouldRotate(size, lines int64) bool {
+	if s.maxSize > 0 && size >= s.maxSize {
+		return true
+	}
+	if s.maxLines > 0 && lines >= s.maxLines {
+		return true
+	}
+	if s.daily && time.Now().Format("2006-01-02") != s.curDay.Load().(string) {
+		return true
+	}
+	return false
+}
+
+// triggerRotate starts a background rotation unless one is already running.
+func (s *FileSink) triggerRotate() {
+	if !atomic.CompareAndSwapInt32(&s.rotating, 0, 1) {
+		return
+	}
+	go s.rotate()
+}
+
+// rotate renames the current file out of the way, reopens Filename, and then
+// compresses/prunes old backups in the background.
+func (s *FileSink) rotate() {
+	defer atomic.StoreInt32(&s.rotating, 0)
+
+	day := time.Now().Format("2006-01-02")
+	backup := s.nextBackupName(day)
+
+	s.muReopen.Lock()
+	err := os.Rename(s.filename, backup)
+	if err == nil || os.IsNotExist(err) {
+		if f, ferr := os.OpenFile(s.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); ferr == nil {
+			s.file.Close()
+			s.file = f
+		}
+	}
+	s.muReopen.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	atomic.StoreInt64(&s.curSize, 0)
+	atomic.StoreInt64(&s.curLines, 0)
+	s.curDay.Store(day)
+
+	if s.compress {
+		backup = s.compressBackup(backup)
+	}
+	s.pruneBackups()
+}
+
+// nextBackupName returns "filename.day.N" for the smallest N not already in use.
+func (s *FileSink) nextBackupName(day string) string {
+	for n := 1; ; n++ {
+		name := s.filename + "." + day + "." + strconv.Itoa(n)
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err := os.Stat(name + ".gz"); os.IsNotExist(err) {
+				return name
+			}
+		}
+	}
+}
+
+// compressBackup gzips name into name+".gz" and removes the uncompressed copy.
+// It returns the path of the file that now holds the backup.
+func (s *FileSink) compressBackup(name string) string {
+	src, err := os.Open(name)
+	if err != nil {
+		return name
+	}
+	defer src.Close()
+
+	dstName := name + ".gz"
+	dst, err := os.OpenFile(dstName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return name
+	}
+	defer dst.Close()
+
+	gw := gzip.NewWriter(dst)
+	if _, err := io.Copy(gw, src); err != nil {
+		gw.Close()
+		os.Remove(dstName)
+		return name
+	}
+	if err := gw.Close(); err != nil {
+		os.Remove(dstName)
+		return name
+	}
+
+	os.Remove(name)
+	return dstName
+}
+
+// pruneBackups removes rotated files beyond maxBackups or older than maxDays.
+func (s *FileSink) pruneBackups() {
+	if s.maxBackups <= 0 && s.maxDays <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(s.filename + ".*")
+	if err != nil {
+		return
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		fi, err1 := os.Stat(matches[i])
+		fj, err2 := os.Stat(matches[j])
+		if err1 != nil || err2 != nil {
+			return matches[i] < matches[j]
+		}
+		return fi.ModTime().After(fj.ModTime())
+	})
+
+	cutoff := time.Now().AddDate(0, 0, -s.maxDays)
+	for idx, name := range matches {
+		fi, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if s.maxBackups > 0 && idx >= s.maxBackups {
+			os.Remove(name)
+			continue
+		}
+		if s.maxDays > 0 && fi.ModTime().Before(cutoff) {
+			os.Remove(name)
+		}
+	}
+}
+
+// StderrSink writes records to os.Stderr, which is convenient when running
+// under systemd/journald. It does not rotate, so Reopen is a no-op.
+type StderrSink struct{}
+
+// NewStderrSink returns a Sink that writes to os.Stderr.
+func NewStderrSink() *StderrSink {
+	return &StderrSink{}
+}
+
+func (s *StderrSink) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	n, err := os.Stderr.Write(data[1:])
+	return n + 1, err
+}
+
+// Reopen is a no-op: os.Stderr has nothing to reopen.
+func (s *StderrSink) Reopen() error {
+	return nil
+}
+
+// SyslogSink writes records to syslog, mapping each record's level to the
+// matching syslog severity (e.g. ErrorLevel becomes LOG_ERR).
+type SyslogSink struct {
+	network  string
+	raddr    string
+	priority syslog.Priority
+	tag      string
+
+	muReopen sync.Mutex
+	w        *syslog.Writer
+}
+
+// NewSyslogSink dials a syslog daemon. network and raddr are passed to
+// syslog.Dial; pass "" for both to use the local syslog daemon.
+func NewSyslogSink(network, raddr string, priority syslog.Priority, tag string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, raddr, priority, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{network: network, raddr: raddr, priority: priority, tag: tag, w: w}, nil
+}
+
+// Write sends data[1:] to syslog at the severity matching the level carried
+// in data[0] (see Sink).
+func (s *SyslogSink) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	level := data[0]
+	msg := string(data[1:])
+
+	s.muReopen.Lock()
+	w := s.w
+	s.muReopen.Unlock()
+
+	var err error
+	switch level {
+	case FatalLevel:
+		err = w.Emerg(msg)
+	case ErrorLevel:
+		err = w.Err(msg)
+	case WarnLevel:
+		err = w.Warning(msg)
+	case DebugLevel, TraceLevel:
+		err = w.Debug(msg)
+	default:
+		err = w.Info(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Reopen redials the syslog daemon, replacing the current connection.
+func (s *SyslogSink) Reopen() error {
+	w, err := syslog.Dial(s.network, s.raddr, s.priority, s.tag)
+	if err != nil {
+		return err
+	}
+
+	s.muReopen.Lock()
+	old := s.w
+	s.w = w
+	s.muReopen.Unlock()
+
+	old.Close()
+	return nil
+}
+
+// SinkLevel pairs a Sink with the least severe level it should receive.
+type SinkLevel struct {
+	Sink  Sink
+	Level byte
+}
+
+// MultiSink fans a record out to several sinks, each filtered independently
+// by its own Level threshold, e.g. sending FATAL/ERROR to syslog while
+// sending everything to a rotated file.
+type MultiSink struct {
+	entries []SinkLevel
+}
+
+// NewMultiSink builds a MultiSink from (Sink, Level) pairs.
+func NewMultiSink(entries ...SinkLevel) *MultiSink {
+	return &MultiSink{entries: entries}
+}
+
+// Write routes data to every entry whose Level admits data[0] (the record's
+// level, see Sink), forwarding data unchanged so each child sink sees the
+// same level-prefixed record MultiSink itself received. It always fans out
+// to every matching entry regardless of earlier failures, but returns the
+// first error encountered so a configured WriteErrorHandler still observes
+// it instead of Write always reporting success.
+func (m *MultiSink) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	level := data[0]
+	var firstErr error
+	for _, e := range m.entries {
+		if level <= e.Level {
+			if _, err := e.Sink.Write(data); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return len(data), firstErr
+}
+
+// Reopen calls Reopen on every sink, returning the first error encountered.
+func (m *MultiSink) Reopen() error {
+	var firstErr error
+	for _, e := range m.entries {
+		if err := e.Sink.Reopen(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
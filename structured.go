@@ -0,0 +1,218 @@
+package logger
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Format selects the encoding Entry uses to serialize a structured record.
+type Format int
+
+const (
+	// LogfmtFormat renders "ts=... level=info msg=\"...\" key=val" lines.
+	LogfmtFormat Format = iota
+	// JSONFormat renders one JSON object per record.
+	JSONFormat
+)
+
+var levelNameLower = map[byte]string{
+	FatalLevel: "fatal",
+	ErrorLevel: "error",
+	WarnLevel:  "warn",
+	InfoLevel:  "info",
+	DebugLevel: "debug",
+	TraceLevel: "trace",
+}
+
+type fieldType int8
+
+const (
+	stringType fieldType = iota
+	intType
+	floatType
+	boolType
+	ifaceType
+)
+
+// Field is a single structured key/value pair attached to an Entry. Use the
+// constructors below (String, Int, Int64, Float64, Bool, Any) rather than
+// building one by hand.
+type Field struct {
+	Key   string
+	Type  fieldType
+	Int   int64
+	Str   string
+	Iface interface{}
+}
+
+// String returns a Field holding a string value.
+func String(key, value string) Field {
+	return Field{Key: key, Type: stringType, Str: value}
+}
+
+// Int returns a Field holding an int value.
+func Int(key string, value int) Field {
+	return Field{Key: key, Type: intType, Int: int64(value)}
+}
+
+// Int64 returns a Field holding an int64 value.
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Type: intType, Int: value}
+}
+
+// Float64 returns a Field holding a float64 value, bit-packed into Int.
+func Float64(key string, value float64) Field {
+	return Field{Key: key, Type: floatType, Int: int64(math.Float64bits(value))}
+}
+
+// Bool returns a Field holding a bool value.
+func Bool(key string, value bool) Field {
+	var v int64
+	if value {
+		v = 1
+	}
+	return Field{Key: key, Type: boolType, Int: v}
+}
+
+// Any returns a Field holding an arbitrary value, formatted with fmt.Sprint
+// when encoded. Prefer the typed constructors where possible.
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Type: ifaceType, Iface: value}
+}
+
+// Entry is a Logger bound to a fixed set of Fields, created by Logger.With.
+// Its Info/Error/... methods emit a structured record (logfmt or JSON,
+// per LoggerConfig.Format) instead of a raw []byte line.
+type Entry struct {
+	logger *Logger
+	fields []Field
+}
+
+// With returns an Entry that attaches kv to every record it emits.
+func (l *Logger) With(kv ...Field) *Entry {
+	return &Entry{logger: l, fields: kv}
+}
+
+func (e *Entry) log(level byte, msg string) {
+	e.logger.emit(level, 0, func(buf *buffer, callerLoc string, stackDump []byte) {
+		if e.logger.format == JSONFormat {
+			encodeJSON(buf, e.logger, level, msg, e.fields, callerLoc, stackDump)
+		} else {
+			encodeLogfmt(buf, e.logger, level, msg, e.fields, callerLoc, stackDump)
+		}
+	})
+}
+
+// Fatal emits msg and the bound fields at FatalLevel.
+func (e *Entry) Fatal(msg string) { e.log(FatalLevel, msg) }
+
+// Error emits msg and the bound fields at ErrorLevel.
+func (e *Entry) Error(msg string) { e.log(ErrorLevel, msg) }
+
+// Warn emits msg and the bound fields at WarnLevel.
+func (e *Entry) Warn(msg string) { e.log(WarnLevel, msg) }
+
+// Info emits msg and the bound fields at InfoLevel.
+func (e *Entry) Info(msg string) { e.log(InfoLevel, msg) }
+
+// Debug emits msg and the bound fields at DebugLevel.
+func (e *Entry) Debug(msg string) { e.log(DebugLevel, msg) }
+
+// Trace emits msg and the bound fields at TraceLevel.
+func (e *Entry) Trace(msg string) { e.log(TraceLevel, msg) }
+
+// encodeLogfmt writes "ts=... level=... caller=... msg=\"...\" key=val ..."
+// into buf without going through fmt.Sprintf. callerLoc and stackDump carry
+// the CallerInfo/StackOnError decoration emit resolved (see Logger.emit);
+// callerLoc is "" and stackDump is nil when that decoration doesn't apply.
+func encodeLogfmt(buf *buffer, l *Logger, level byte, msg string, fields []Field, callerLoc string, stackDump []byte) {
+	var scratch [32]byte
+
+	buf.append([]byte("ts="))
+	buf.append(*(l.getTime()))
+	buf.append([]byte("level="))
+	buf.append([]byte(levelNameLower[level]))
+	if callerLoc != "" {
+		buf.append([]byte(" caller="))
+		buf.append(strconv.AppendQuote(scratch[:0], callerLoc))
+	}
+	buf.append([]byte(" msg="))
+	buf.append(strconv.AppendQuote(scratch[:0], msg))
+
+	for _, f := range fields {
+		buf.append([]byte{' '})
+		buf.append([]byte(f.Key))
+		buf.append([]byte{'='})
+		appendLogfmtValue(buf, f)
+	}
+
+	if stackDump != nil {
+		buf.append([]byte(" stack="))
+		buf.append(strconv.AppendQuote(scratch[:0], string(stackDump)))
+	}
+}
+
+func appendLogfmtValue(buf *buffer, f Field) {
+	var scratch [32]byte
+	switch f.Type {
+	case stringType:
+		buf.append(strconv.AppendQuote(scratch[:0], f.Str))
+	case intType:
+		buf.append(strconv.AppendInt(scratch[:0], f.Int, 10))
+	case floatType:
+		buf.append(strconv.AppendFloat(scratch[:0], math.Float64frombits(uint64(f.Int)), 'g', -1, 64))
+	case boolType:
+		buf.append(strconv.AppendBool(scratch[:0], f.Int != 0))
+	default:
+		buf.append(strconv.AppendQuote(scratch[:0], fmt.Sprint(f.Iface)))
+	}
+}
+
+// encodeJSON writes a single JSON object into buf without going through
+// fmt.Sprintf or encoding/json. callerLoc and stackDump carry the
+// CallerInfo/StackOnError decoration emit resolved (see Logger.emit);
+// callerLoc is "" and stackDump is nil when that decoration doesn't apply.
+func encodeJSON(buf *buffer, l *Logger, level byte, msg string, fields []Field, callerLoc string, stackDump []byte) {
+	var scratch [32]byte
+
+	buf.append([]byte(`{"ts":`))
+	buf.append(strconv.AppendQuote(scratch[:0], string(*(l.getTime()))))
+	buf.append([]byte(`,"level":"`))
+	buf.append([]byte(levelNameLower[level]))
+	buf.append([]byte(`"`))
+	if callerLoc != "" {
+		buf.append([]byte(`,"caller":`))
+		buf.append(strconv.AppendQuote(scratch[:0], callerLoc))
+	}
+	buf.append([]byte(`,"msg":`))
+	buf.append(strconv.AppendQuote(scratch[:0], msg))
+
+	for _, f := range fields {
+		buf.append([]byte{','})
+		buf.append(strconv.AppendQuote(scratch[:0], f.Key))
+		buf.append([]byte{':'})
+		appendJSONValue(buf, f)
+	}
+	if stackDump != nil {
+		buf.append([]byte(`,"stack":`))
+		buf.append(strconv.AppendQuote(scratch[:0], string(stackDump)))
+	}
+	buf.append([]byte{'}'})
+}
+
+func appendJSONValue(buf *buffer, f Field) {
+	var scratch [32]byte
+	switch f.Type {
+	case stringType:
+		buf.append(strconv.AppendQuote(scratch[:0], f.Str))
+	case intType:
+		buf.append(strconv.AppendInt(scratch[:0], f.Int, 10))
+	case floatType:
+		buf.append(strconv.AppendFloat(scratch[:0], math.Float64frombits(uint64(f.Int)), 'g', -1, 64))
+	case boolType:
+		buf.append(strconv.AppendBool(scratch[:0], f.Int != 0))
+	default:
+		buf.append(strconv.AppendQuote(scratch[:0], fmt.Sprint(f.Iface)))
+	}
+}
@@ -0,0 +1,175 @@
+package logger
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// vModuleEntry is one parsed "pattern=level" entry from a SetVModule spec.
+type vModuleEntry struct {
+	pattern string
+	level   int32
+}
+
+// Verbose is returned by Logger.V and gates a log call behind a verbosity level,
+// mirroring glog's V-level logging. LogInfo and LogInfof are no-ops when the
+// requested level is above the effective verbosity for the caller.
+type Verbose struct {
+	enabled bool
+	logger  *Logger
+}
+
+// LogInfo logs data at InfoLevel if this Verbose is enabled.
+func (v Verbose) LogInfo(data []byte) {
+	if !v.enabled {
+		return
+	}
+	v.logger.outputDepth(InfoLevel, 0, data)
+}
+
+// LogInfof formats according to format and logs the result at InfoLevel if this Verbose is enabled.
+func (v Verbose) LogInfof(format string, args ...interface{}) {
+	if !v.enabled {
+		return
+	}
+	v.logger.outputDepth(InfoLevel, 0, []byte(fmt.Sprintf(format, args...)))
+}
+
+// SetV sets the global verbosity level used by V for callers not matched by SetVModule.
+func (l *Logger) SetV(level int) {
+	atomic.StoreInt32(&l.vLevel, int32(level))
+	l.vCache.Store(&sync.Map{})
+}
+
+// SetVModule configures per-file verbosity overrides from a comma-separated
+// "pattern=level" spec, e.g. "client=2,rpc/*=3,foo/bar.go=4". A pattern without
+// a "/" matches the caller's file name alone; a pattern with "/" is matched
+// against that many trailing path components. Patterns support the '*' and '?'
+// glob wildcards understood by path/filepath.Match.
+func (l *Logger) SetVModule(spec string) error {
+	entries, err := parseVModule(spec)
+	if err != nil {
+		return err
+	}
+
+	l.muUpdate.Lock()
+	l.vModule = entries
+	l.muUpdate.Unlock()
+	l.vCache.Store(&sync.Map{})
+	return nil
+}
+
+func parseVModule(spec string) ([]vModuleEntry, error) {
+	var entries []vModuleEntry
+	for _, part := range strings.Split(spec, ",") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid vmodule entry %q", part)
+		}
+		level, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid vmodule level in %q: %v", part, err)
+		}
+		entries = append(entries, vModuleEntry{pattern: kv[0], level: int32(level)})
+	}
+	return entries, nil
+}
+
+// vModuleMatch reports whether pattern matches file, a caller's source path.
+// The pattern's path components are compared against the same number of
+// trailing components of file (with its ".go" suffix stripped).
+func vModuleMatch(pattern, file string) bool {
+	pattern = strings.TrimSuffix(pattern, ".go")
+	file = strings.TrimSuffix(file, ".go")
+	pComponents := strings.Split(pattern, "/")
+	fComponents := strings.Split(file, "/")
+	if len(fComponents) < len(pComponents) {
+		return false
+	}
+	fComponents = fComponents[len(fComponents)-len(pComponents):]
+
+	for i, p := range pComponents {
+		ok, err := filepath.Match(p, fComponents[i])
+		if err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// V reports the verbosity gate for level at the caller's call site. The
+// threshold for a call site is matched against the SetVModule patterns once per
+// program counter and cached; every call, including repeat calls from the same
+// call site, still runs runtime.Caller to find that program counter before the
+// cache lookup.
+func (l *Logger) V(level int) Verbose {
+	pc, file, _, ok := runtime.Caller(1)
+	if !ok {
+		return Verbose{enabled: int32(level) <= atomic.LoadInt32(&l.vLevel), logger: l}
+	}
+
+	cache, _ := l.vCache.Load().(*sync.Map)
+	if cache != nil {
+		if threshold, ok := cache.Load(pc); ok {
+			return Verbose{enabled: int32(level) <= threshold.(int32), logger: l}
+		}
+	}
+
+	threshold := atomic.LoadInt32(&l.vLevel)
+	l.muUpdate.Lock()
+	modules := l.vModule
+	l.muUpdate.Unlock()
+	for _, m := range modules {
+		if vModuleMatch(m.pattern, file) {
+			threshold = m.level
+			break
+		}
+	}
+
+	if cache != nil {
+		cache.Store(pc, threshold)
+	}
+	return Verbose{enabled: int32(level) <= threshold, logger: l}
+}
+
+// SetBacktraceAt configures the logger to append a stack trace to any record whose
+// caller matches one of the comma-separated "file.go:line" entries in spec, e.g.
+// "client.go:123,server.go:45". Pass an empty string to disable it.
+func (l *Logger) SetBacktraceAt(spec string) error {
+	set := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, ":") {
+			return fmt.Errorf("invalid backtrace entry %q, want file.go:line", part)
+		}
+		set[part] = true
+	}
+
+	l.muUpdate.Lock()
+	l.backtraceAt = set
+	l.muUpdate.Unlock()
+	return nil
+}
+
+// stackTrace returns the current goroutine's stack, growing the buffer until
+// the dump fits.
+func stackTrace() []byte {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}